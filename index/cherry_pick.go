@@ -0,0 +1,234 @@
+package index
+
+import (
+	"context"
+
+	"github.com/treeverse/lakefs/db"
+	"github.com/treeverse/lakefs/ident"
+	"github.com/treeverse/lakefs/index/errors"
+	"github.com/treeverse/lakefs/index/merkle"
+	"github.com/treeverse/lakefs/index/model"
+	pth "github.com/treeverse/lakefs/index/path"
+	"github.com/treeverse/lakefs/index/store"
+
+	"golang.org/x/xerrors"
+)
+
+// applyCommitPatch three-way diffs sourceTree against branch's current tip,
+// relative to baseTree, and applies the result onto branch through the
+// workspace + partialCommit path, the same way a regular write does. It then
+// commits the outcome as a single-parent child of the branch's previous tip,
+// recording sourceCommitId under metadata[metadataKey]. Conflicts are
+// reported exactly like Merge reports them: ErrMergeConflict plus a
+// persisted MergeState the caller can inspect and resolve via
+// AbortMerge/ContinueMerge. CherryPick and RevertAsCommit are both
+// instances of this: picking applies sourceTree=commit, baseTree=parent;
+// reverting applies sourceTree=parent, baseTree=commit.
+func applyCommitPatch(ctx context.Context, tx store.RepoOperations, index *KVIndex, branch, sourceCommitId, sourceTree, baseTree, committer, message, metadataKey string, ts int64) (*model.Commit, error) {
+	if err := rebaseGuard(tx, branch); err != nil {
+		return nil, err
+	}
+	// a cherry-pick/revert can't start while a merge is already in progress on this branch
+	if _, err := tx.ReadMergeState(branch); err == nil {
+		return nil, errors.ErrMergeInProgress
+	} else if !xerrors.Is(err, db.ErrNotFound) {
+		return nil, err
+	}
+	repo, err := tx.ReadRepo()
+	if err != nil {
+		return nil, err
+	}
+	branchData, err := tx.ReadBranch(branch)
+	if err != nil {
+		return nil, err
+	}
+	uncommitted, err := hasUncommittedChanges(tx, branch)
+	if err != nil {
+		return nil, err
+	}
+	if uncommitted {
+		return nil, errors.ErrDestinationNotCommitted
+	}
+
+	diffs, err := merkle.Diff(tx,
+		merkle.New(sourceTree),
+		merkle.New(branchData.GetCommitRoot()),
+		merkle.New(baseTree))
+	if err != nil {
+		return nil, err
+	}
+	conflicts, err := buildConflicts(tx, sourceTree, branchData.GetCommitRoot(), diffs)
+	if err != nil {
+		return nil, err
+	}
+	if len(conflicts) > 0 {
+		if err := tx.WriteMergeState(branch, &MergeState{
+			Destination:  branch,
+			Source:       sourceCommitId,
+			SourceCommit: sourceCommitId,
+			BaseCommit:   branchData.GetCommit(),
+			Conflicts:    conflicts,
+		}); err != nil {
+			return nil, err
+		}
+		return nil, errors.ErrMergeConflict
+	}
+
+	m := merkle.New(sourceTree)
+	for _, dif := range diffs {
+		if dif.Direction == merkle.DifferenceDirectionRight {
+			continue // unrelated change already on branch, nothing to apply
+		}
+		var e *model.Entry
+		if dif.Type != merkle.DifferenceTypeRemoved {
+			e, err = m.GetEntry(tx, dif.Path, dif.PathType)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			e = new(model.Entry)
+			e.Name = pth.New(dif.Path).Basename()
+			e.Type = dif.PathType
+		}
+		err = writeEntryToWorkspace(ctx, tx, index.partialCommitPolicy, repo, branch, dif.Path, &model.WorkspaceEntry{
+			Path:      dif.Path,
+			Entry:     e,
+			Tombstone: dif.Type == merkle.DifferenceTypeRemoved,
+		}, ts)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := partialCommit(ctx, tx, branch, ts); err != nil {
+		return nil, err
+	}
+
+	branchData, err = tx.ReadBranch(branch)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := doCommitUpdates(tx, branchData, committer, message,
+		[]string{branchData.GetCommit()},
+		map[string]string{metadataKey: sourceCommitId},
+		ts, index.signer)
+	if err != nil {
+		return nil, err
+	}
+	return commit.(*model.Commit), nil
+}
+
+// CherryPickDiff returns the patch commitId introduced, without writing
+// anything: it diffs commitId's tree against its first parent's tree,
+// relative to that same parent tree. branch is validated but not read, so
+// the result does not take branch's current tip into account - unlike
+// applyCommitPatch, which diffs against that tip - and does not show which
+// of these changes are already on branch or would conflict there.
+//
+// CherryPickDiff does not apply anything and does not detect or report
+// conflicts; CherryPick, defined below, applies the patch and returns the
+// resulting *model.Commit.
+func (index *KVIndex) CherryPickDiff(repoId, branch, commitId string) (merkle.Differences, error) {
+	err := ValidateAll(
+		ValidateRepoId(repoId),
+		ValidateRef(branch),
+		ValidateCommitID(commitId))
+	if err != nil {
+		return nil, err
+	}
+	diffs, err := index.kv.RepoReadTransactCtx(index.ctx, repoId, func(tx store.RepoReadOnlyOperations) (interface{}, error) {
+		commitData, err := tx.ReadCommit(commitId)
+		if err != nil {
+			return nil, err
+		}
+		parentTree, err := firstParentTree(tx, commitData)
+		if err != nil {
+			return nil, err
+		}
+		return merkle.Diff(tx,
+			merkle.New(commitData.GetTree()),
+			merkle.New(parentTree),
+			merkle.New(parentTree))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return diffs.(merkle.Differences), nil
+}
+
+// CherryPick applies the changes introduced by commitId (relative to its
+// first parent) onto branch's current tip, without pulling in the rest of
+// commitId's history the way Merge would. The resulting commit records
+// commitId under the "cherry-picked-from" metadata key.
+func (index *KVIndex) CherryPick(repoId, branch, commitId, committer string) (*model.Commit, error) {
+	err := ValidateAll(
+		ValidateRepoId(repoId),
+		ValidateRef(branch),
+		ValidateCommitID(commitId))
+	if err != nil {
+		return nil, err
+	}
+	ts := index.tsGenerator()
+	commit, err := index.kv.RepoTransactCtx(index.ctx, repoId, func(tx store.RepoOperations) (interface{}, error) {
+		commitData, err := tx.ReadCommit(commitId)
+		if err != nil {
+			return nil, err
+		}
+		parentTree, err := firstParentTree(tx, commitData)
+		if err != nil {
+			return nil, err
+		}
+		return applyCommitPatch(index.ctx, tx, index, branch, commitId,
+			commitData.GetTree(), parentTree,
+			committer, "Cherry-pick "+commitId, "cherry-picked-from", ts)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return commit.(*model.Commit), nil
+}
+
+// RevertAsCommit undoes the changes introduced by commitId (relative to its
+// first parent) on top of branch's current tip, recording the result as a
+// new commit rather than rewinding branch the way RevertCommit does. The
+// resulting commit records commitId under the "reverted-commit" metadata key.
+func (index *KVIndex) RevertAsCommit(repoId, branch, commitId, committer string) (*model.Commit, error) {
+	err := ValidateAll(
+		ValidateRepoId(repoId),
+		ValidateRef(branch),
+		ValidateCommitID(commitId))
+	if err != nil {
+		return nil, err
+	}
+	ts := index.tsGenerator()
+	commit, err := index.kv.RepoTransactCtx(index.ctx, repoId, func(tx store.RepoOperations) (interface{}, error) {
+		commitData, err := tx.ReadCommit(commitId)
+		if err != nil {
+			return nil, err
+		}
+		parentTree, err := firstParentTree(tx, commitData)
+		if err != nil {
+			return nil, err
+		}
+		return applyCommitPatch(index.ctx, tx, index, branch, commitId,
+			parentTree, commitData.GetTree(),
+			committer, "Revert "+commitId, "reverted-commit", ts)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return commit.(*model.Commit), nil
+}
+
+// firstParentTree returns the tree of commit's first parent, or the empty
+// tree if commit has no parents.
+func firstParentTree(tx store.RepoReadOnlyOperations, commit *model.Commit) (string, error) {
+	parents := commit.GetParents()
+	if len(parents) == 0 {
+		return ident.Empty(), nil
+	}
+	parent, err := tx.ReadCommit(parents[0])
+	if err != nil {
+		return "", err
+	}
+	return parent.GetTree(), nil
+}
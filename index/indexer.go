@@ -3,7 +3,6 @@ package index
 import (
 	"context"
 	"fmt"
-	"math/rand"
 	"strings"
 	"time"
 
@@ -51,25 +50,50 @@ type Index interface {
 	GetCommit(repoId, commitId string) (*model.Commit, error)
 	GetCommitLog(repoId, fromCommitId string, results int, after string) ([]*model.Commit, bool, error)
 	DeleteBranch(repoId, branch string) error
+	CreateTag(repoId, tag, ref, committer, message string) (*model.Tag, error)
+	GetTag(repoId, tag string) (*model.Tag, error)
+	DeleteTag(repoId, tag string) error
+	ListTags(repoId string, amount int, after string) ([]*model.Tag, bool, error)
+	ListTagsByPrefix(repoId, prefix string, amount int, after string) ([]*model.Tag, bool, error)
 	Diff(repoId, leftRef, rightRef string) (merkle.Differences, error)
 	DiffWorkspace(repoId, branch string) (merkle.Differences, error)
+	Status(repoId, branch string) (*WorkspaceStatus, error)
+	HasUncommittedChanges(repoId, branch string) (bool, error)
 	RevertCommit(repoId, branch, commit string) error
 	RevertPath(repoId, branch, path string) error
 	RevertObject(repoId, branch, path string) error
-	Merge(repoId, source, destination, userId string) (merkle.Differences, error)
+	Merge(repoId, source, destination, userId string, opts *MergeOptions) (merkle.Differences, merkle.Conflicts, error)
+	AbortMerge(repoId, destination string) error
+	ContinueMerge(repoId, destination, userId string) (merkle.Differences, error)
+	GetMergeState(repoId, destination string) (*MergeState, error)
+	ResolveConflict(repoId, branch, path string, resolution ConflictResolution, custom *model.Object) error
+	MergeMultiple(repoId string, sources []string, destination, userId, message string) (*model.Commit, error)
+	CherryPickDiff(repoId, branch, commitId string) (merkle.Differences, error)
+	CherryPick(repoId, branch, commitId, committer string) (*model.Commit, error)
+	RevertAsCommit(repoId, branch, commitId, committer string) (*model.Commit, error)
+	Rebase(repoId, branch, onto, userId string) (*RebaseState, error)
+	RebaseContinue(repoId, branch, userId string) (*RebaseState, error)
+	RebaseSkip(repoId, branch, userId string) (*RebaseState, error)
+	RebaseAbort(repoId, branch string) error
+	RegisterSignerKey(repoId, keyId string, publicKey []byte) error
+	VerifyCommit(repoId, commitId string) (*SignatureStatus, error)
 	CreateRepo(repoId, bucketName, defaultBranch string) error
 	ListRepos(amount int, after string) ([]*model.Repo, bool, error)
 	GetRepo(repoId string) (*model.Repo, error)
 	DeleteRepo(repoId string) error
 }
 
-func writeEntryToWorkspace(tx store.RepoOperations, repo *model.Repo, branch, path string, entry *model.WorkspaceEntry) error {
+func writeEntryToWorkspace(ctx context.Context, tx store.RepoOperations, policy PartialCommitPolicy, repo *model.Repo, branch, path string, entry *model.WorkspaceEntry, ts int64) error {
 	err := tx.WriteToWorkspacePath(branch, path, entry)
 	if err != nil {
 		return err
 	}
-	if shouldPartiallyCommit(repo) {
-		err = partialCommit(tx, branch)
+	shouldCommit, err := policy.ShouldPartiallyCommit(tx, repo, branch, ts)
+	if err != nil {
+		return err
+	}
+	if shouldCommit {
+		err = partialCommit(ctx, tx, branch, ts)
 		if err != nil {
 			return err
 		}
@@ -77,12 +101,7 @@ func writeEntryToWorkspace(tx store.RepoOperations, repo *model.Repo, branch, pa
 	return nil
 }
 
-func shouldPartiallyCommit(repo *model.Repo) bool {
-	chosen := rand.Float32()
-	return chosen < repo.GetPartialCommitRatio()
-}
-
-func partialCommit(tx store.RepoOperations, branch string) error {
+func partialCommit(ctx context.Context, tx store.RepoOperations, branch string, ts int64) error {
 	// see if we have any changes that weren't applied
 	wsEntries, err := tx.ListWorkspace(branch)
 	if err != nil {
@@ -102,7 +121,7 @@ func partialCommit(tx store.RepoOperations, branch string) error {
 
 	// update the immutable Merkle tree, getting back a new tree
 	tree := merkle.New(branchData.GetWorkspaceRoot())
-	tree, err = tree.Update(tx, wsEntries)
+	tree, err = tree.Update(ctx, tx, wsEntries)
 	if err != nil {
 		return err
 	}
@@ -115,10 +134,11 @@ func partialCommit(tx store.RepoOperations, branch string) error {
 
 	// update branch pointer to point at new workspace
 	err = tx.WriteBranch(branch, &model.Branch{
-		Name:          branch,
-		Commit:        branchData.GetCommit(),
-		CommitRoot:    branchData.GetCommitRoot(),
-		WorkspaceRoot: tree.Root(), // does this happen properly?
+		Name:                branch,
+		Commit:              branchData.GetCommit(),
+		CommitRoot:          branchData.GetCommitRoot(),
+		WorkspaceRoot:       tree.Root(), // does this happen properly?
+		LastPartialCommitAt: ts,
 	})
 	if err != nil {
 		return err
@@ -133,8 +153,10 @@ func gc(tx store.RepoOperations, addr string) {
 }
 
 type KVIndex struct {
-	kv          store.Store
-	tsGenerator TimeGenerator
+	kv                  store.Store
+	tsGenerator         TimeGenerator
+	signer              Signer
+	partialCommitPolicy PartialCommitPolicy
 
 	ctx context.Context
 }
@@ -158,11 +180,21 @@ func WithContext(ctx context.Context) Option {
 	}
 }
 
+// WithPartialCommitPolicy overrides the policy used to decide, after each
+// workspace write, whether to eagerly fold the pending workspace into the
+// branch's immutable Merkle tree. The default is ProbabilisticPartialCommitPolicy.
+func WithPartialCommitPolicy(policy PartialCommitPolicy) Option {
+	return func(kvi *KVIndex) {
+		kvi.partialCommitPolicy = policy
+	}
+}
+
 func NewKVIndex(kv store.Store, opts ...Option) *KVIndex {
 	kvi := &KVIndex{
-		kv:          kv,
-		tsGenerator: func() int64 { return time.Now().Unix() },
-		ctx:         context.Background(),
+		kv:                  kv,
+		tsGenerator:         func() int64 { return time.Now().Unix() },
+		ctx:                 context.Background(),
+		partialCommitPolicy: ProbabilisticPartialCommitPolicy{},
 	}
 	for _, opt := range opts {
 		opt(kvi)
@@ -206,7 +238,22 @@ func resolveRef(tx store.RepoReadOnlyOperations, ref string) (*reference, error)
 	// treat this as a branch name
 	branch, err := tx.ReadBranch(ref)
 	if err != nil {
-		return nil, err
+		if !xerrors.Is(err, db.ErrNotFound) {
+			return nil, err
+		}
+		// not a branch either - maybe it's a tag
+		tag, tagErr := tx.ReadTag(ref)
+		if tagErr != nil {
+			if xerrors.Is(tagErr, db.ErrNotFound) {
+				return nil, err // keep the original "branch not found" error
+			}
+			return nil, tagErr
+		}
+		commit, err := tx.ReadCommit(tag.GetCommitId())
+		if err != nil {
+			return nil, err
+		}
+		return &reference{commit: commit}, nil
 	}
 	commit, err := tx.ReadCommit(branch.GetCommit())
 	if err != nil {
@@ -227,9 +274,11 @@ func (index *KVIndex) log() logging.Logger {
 // Business logic
 func (index *KVIndex) WithContext(ctx context.Context) Index {
 	return &KVIndex{
-		kv:          index.kv,
-		tsGenerator: index.tsGenerator,
-		ctx:         ctx,
+		kv:                  index.kv,
+		tsGenerator:         index.tsGenerator,
+		signer:              index.signer,
+		partialCommitPolicy: index.partialCommitPolicy,
+		ctx:                 ctx,
 	}
 }
 
@@ -242,7 +291,7 @@ func (index *KVIndex) ReadObject(repoId, ref, path string) (*model.Object, error
 		return nil, err
 	}
 
-	obj, err := index.kv.RepoReadTransact(repoId, func(tx store.RepoReadOnlyOperations) (interface{}, error) {
+	obj, err := index.kv.RepoReadTransactCtx(index.ctx, repoId, func(tx store.RepoReadOnlyOperations) (interface{}, error) {
 		_, err := tx.ReadRepo()
 		if err != nil {
 			return nil, err
@@ -337,7 +386,7 @@ func (index *KVIndex) ReadEntry(repoId, branch, path string, typ model.Entry_Typ
 	if err != nil {
 		return nil, err
 	}
-	entry, err := index.kv.RepoReadTransact(repoId, func(tx store.RepoReadOnlyOperations) (interface{}, error) {
+	entry, err := index.kv.RepoReadTransactCtx(index.ctx, repoId, func(tx store.RepoReadOnlyOperations) (interface{}, error) {
 		return readEntry(tx, branch, path, typ)
 	})
 	if err != nil {
@@ -353,7 +402,7 @@ func (index *KVIndex) ReadRootObject(repoId, ref string) (*model.Root, error) {
 	if err != nil {
 		return nil, err
 	}
-	root, err := index.kv.RepoReadTransact(repoId, func(tx store.RepoReadOnlyOperations) (i interface{}, err error) {
+	root, err := index.kv.RepoReadTransactCtx(index.ctx, repoId, func(tx store.RepoReadOnlyOperations) (i interface{}, err error) {
 		_, err = tx.ReadRepo()
 		if err != nil {
 			return nil, err
@@ -403,7 +452,7 @@ func (index *KVIndex) WriteFile(repoId, branch, path string, entry *model.Entry,
 	if err != nil {
 		return err
 	}
-	_, err = index.kv.RepoTransact(repoId, func(tx store.RepoOperations) (interface{}, error) {
+	_, err = index.kv.RepoTransactCtx(index.ctx, repoId, func(tx store.RepoOperations) (interface{}, error) {
 		repo, err := tx.ReadRepo()
 		if err != nil {
 			return nil, err
@@ -413,10 +462,10 @@ func (index *KVIndex) WriteFile(repoId, branch, path string, entry *model.Entry,
 			index.log().WithError(err).Error("could not write object")
 			return nil, err
 		}
-		err = writeEntryToWorkspace(tx, repo, branch, path, &model.WorkspaceEntry{
+		err = writeEntryToWorkspace(index.ctx, tx, index.partialCommitPolicy, repo, branch, path, &model.WorkspaceEntry{
 			Path:  path,
 			Entry: entry,
-		})
+		}, index.tsGenerator())
 		if err != nil {
 			index.log().WithError(err).Error("could not write workspace entry")
 		}
@@ -433,15 +482,15 @@ func (index *KVIndex) WriteEntry(repoId, branch, path string, entry *model.Entry
 	if err != nil {
 		return err
 	}
-	_, err = index.kv.RepoTransact(repoId, func(tx store.RepoOperations) (interface{}, error) {
+	_, err = index.kv.RepoTransactCtx(index.ctx, repoId, func(tx store.RepoOperations) (interface{}, error) {
 		repo, err := tx.ReadRepo()
 		if err != nil {
 			return nil, err
 		}
-		err = writeEntryToWorkspace(tx, repo, branch, path, &model.WorkspaceEntry{
+		err = writeEntryToWorkspace(index.ctx, tx, index.partialCommitPolicy, repo, branch, path, &model.WorkspaceEntry{
 			Path:  path,
 			Entry: entry,
-		})
+		}, index.tsGenerator())
 		if err != nil {
 			index.log().WithError(err).Error("could not write workspace entry")
 		}
@@ -459,7 +508,7 @@ func (index *KVIndex) WriteObject(repoId, branch, path string, object *model.Obj
 		return err
 	}
 	timestamp := index.tsGenerator()
-	_, err = index.kv.RepoTransact(repoId, func(tx store.RepoOperations) (interface{}, error) {
+	_, err = index.kv.RepoTransactCtx(index.ctx, repoId, func(tx store.RepoOperations) (interface{}, error) {
 		addr := ident.Hash(object)
 		err := tx.WriteObject(addr, object)
 		if err != nil {
@@ -470,7 +519,7 @@ func (index *KVIndex) WriteObject(repoId, branch, path string, object *model.Obj
 			return nil, err
 		}
 		p := pth.New(path)
-		err = writeEntryToWorkspace(tx, repo, branch, path, &model.WorkspaceEntry{
+		err = writeEntryToWorkspace(index.ctx, tx, index.partialCommitPolicy, repo, branch, path, &model.WorkspaceEntry{
 			Path: p.String(),
 			Entry: &model.Entry{
 				Name:      pth.New(path).Basename(),
@@ -480,7 +529,7 @@ func (index *KVIndex) WriteObject(repoId, branch, path string, object *model.Obj
 				Size:      object.GetSize(),
 				Checksum:  object.GetChecksum(),
 			},
-		})
+		}, timestamp)
 		if err != nil {
 			index.log().WithError(err).Error("could not write workspace entry")
 		}
@@ -498,7 +547,7 @@ func (index *KVIndex) DeleteObject(repoId, branch, path string) error {
 		return err
 	}
 	ts := index.tsGenerator()
-	_, err = index.kv.RepoTransact(repoId, func(tx store.RepoOperations) (interface{}, error) {
+	_, err = index.kv.RepoTransactCtx(index.ctx, repoId, func(tx store.RepoOperations) (interface{}, error) {
 		repo, err := tx.ReadRepo()
 		if err != nil {
 			return nil, err
@@ -551,7 +600,7 @@ func (index *KVIndex) DeleteObject(repoId, branch, path string) error {
 		}
 
 		if merkleEntry != nil {
-			err = writeEntryToWorkspace(tx, repo, branch, path, &model.WorkspaceEntry{
+			err = writeEntryToWorkspace(index.ctx, tx, index.partialCommitPolicy, repo, branch, path, &model.WorkspaceEntry{
 				Path: path,
 				Entry: &model.Entry{
 					Name:      pth.New(path).Basename(),
@@ -559,7 +608,7 @@ func (index *KVIndex) DeleteObject(repoId, branch, path string) error {
 					Type:      model.Entry_OBJECT,
 				},
 				Tombstone: true,
-			})
+			}, ts)
 			if err != nil {
 				index.log().WithError(err).Error("could not write workspace tombstone")
 			}
@@ -581,7 +630,7 @@ func (index *KVIndex) ListBranchesByPrefix(repoId string, prefix string, amount
 		results []*model.Branch
 	}
 
-	entries, err := index.kv.RepoTransact(repoId, func(tx store.RepoOperations) (interface{}, error) {
+	entries, err := index.kv.RepoTransactCtx(index.ctx, repoId, func(tx store.RepoOperations) (interface{}, error) {
 		// we're reading the repo to add it to this transaction's conflict range
 		// but also to ensure it exists
 		_, err := tx.ReadRepo()
@@ -618,7 +667,7 @@ func (index *KVIndex) ListObjectsByPrefix(repoId, ref, path, from string, result
 		hasMore bool
 		results []*model.Entry
 	}
-	entries, err := index.kv.RepoTransact(repoId, func(tx store.RepoOperations) (interface{}, error) {
+	entries, err := index.kv.RepoTransactCtx(index.ctx, repoId, func(tx store.RepoOperations) (interface{}, error) {
 		_, err := tx.ReadRepo()
 		if err != nil {
 			return nil, err
@@ -631,7 +680,7 @@ func (index *KVIndex) ListObjectsByPrefix(repoId, ref, path, from string, result
 
 		var root string
 		if reference.isBranch {
-			err := partialCommit(tx, reference.branch.GetName()) // block on this since we traverse the tree immediately after
+			err := partialCommit(index.ctx, tx, reference.branch.GetName(), index.tsGenerator()) // block on this since we traverse the tree immediately after
 			if err != nil {
 				return nil, err
 			}
@@ -645,7 +694,7 @@ func (index *KVIndex) ListObjectsByPrefix(repoId, ref, path, from string, result
 		}
 
 		tree := merkle.New(root)
-		res, hasMore, err := tree.PrefixScan(tx, path, from, results, descend)
+		res, hasMore, err := tree.PrefixScan(index.ctx, tx, path, from, results, descend)
 		if err != nil {
 			log.WithError(err).Error("could not scan tree")
 			return nil, err
@@ -666,7 +715,7 @@ func (index *KVIndex) ResetBranch(repoId, branch string) error {
 		return err
 	}
 	// clear workspace, set branch workspace root back to commit root
-	_, err = index.kv.RepoTransact(repoId, func(tx store.RepoOperations) (interface{}, error) {
+	_, err = index.kv.RepoTransactCtx(index.ctx, repoId, func(tx store.RepoOperations) (interface{}, error) {
 		err := tx.ClearWorkspace(branch)
 		if err != nil {
 			return nil, err
@@ -693,7 +742,7 @@ func (index *KVIndex) CreateBranch(repoId, branch, ref string) (*model.Branch, e
 	if err != nil {
 		return nil, err
 	}
-	branchData, err := index.kv.RepoTransact(repoId, func(tx store.RepoOperations) (interface{}, error) {
+	branchData, err := index.kv.RepoTransactCtx(index.ctx, repoId, func(tx store.RepoOperations) (interface{}, error) {
 		// ensure it doesn't exist yet
 		_, err := tx.ReadBranch(branch)
 		if err != nil && !xerrors.Is(err, db.ErrNotFound) {
@@ -702,6 +751,12 @@ func (index *KVIndex) CreateBranch(repoId, branch, ref string) (*model.Branch, e
 		} else if err == nil {
 			return nil, errors.ErrBranchAlreadyExists
 		}
+		// a branch may also not shadow an existing tag - see CreateTag
+		if _, err := tx.ReadTag(branch); err == nil {
+			return nil, errors.ErrTagAlreadyExists
+		} else if !xerrors.Is(err, db.ErrNotFound) {
+			return nil, err
+		}
 		// read resolve reference
 		reference, err := resolveRef(tx, ref)
 		if err != nil {
@@ -729,7 +784,7 @@ func (index *KVIndex) GetBranch(repoId, branch string) (*model.Branch, error) {
 	if err != nil {
 		return nil, err
 	}
-	brn, err := index.kv.RepoReadTransact(repoId, func(tx store.RepoReadOnlyOperations) (i interface{}, err error) {
+	brn, err := index.kv.RepoReadTransactCtx(index.ctx, repoId, func(tx store.RepoReadOnlyOperations) (i interface{}, err error) {
 		return tx.ReadBranch(branch)
 	})
 	if err != nil {
@@ -738,7 +793,7 @@ func (index *KVIndex) GetBranch(repoId, branch string) (*model.Branch, error) {
 	return brn.(*model.Branch), nil
 }
 
-func doCommitUpdates(tx store.RepoOperations, branchData *model.Branch, committer, message string, parents []string, metadata map[string]string, ts int64) (interface{}, error) {
+func doCommitUpdates(tx store.RepoOperations, branchData *model.Branch, committer, message string, parents []string, metadata map[string]string, ts int64, signer Signer) (interface{}, error) {
 	commit := &model.Commit{
 		Tree:      branchData.GetWorkspaceRoot(),
 		Parents:   parents,
@@ -747,6 +802,14 @@ func doCommitUpdates(tx store.RepoOperations, branchData *model.Branch, committe
 		Timestamp: ts,
 		Metadata:  metadata,
 	}
+	if signer != nil {
+		sig, err := signer.Sign(ident.Payload(commit))
+		if err != nil {
+			return nil, xerrors.Errorf("could not sign commit: %w", err)
+		}
+		commit.Signature = sig
+		commit.SignerKeyId = signer.KeyId()
+	}
 	commitAddr := ident.Hash(commit)
 	commit.Address = commitAddr
 	err := tx.WriteCommit(commitAddr, commit)
@@ -768,8 +831,11 @@ func (index *KVIndex) Commit(repoId, branch, message, committer string, metadata
 		return nil, err
 	}
 	ts := index.tsGenerator()
-	commit, err := index.kv.RepoTransact(repoId, func(tx store.RepoOperations) (interface{}, error) {
-		err := partialCommit(tx, branch)
+	commit, err := index.kv.RepoTransactCtx(index.ctx, repoId, func(tx store.RepoOperations) (interface{}, error) {
+		if err := rebaseGuard(tx, branch); err != nil {
+			return nil, err
+		}
+		err := partialCommit(index.ctx, tx, branch, ts)
 		if err != nil {
 			return nil, err
 		}
@@ -777,7 +843,7 @@ func (index *KVIndex) Commit(repoId, branch, message, committer string, metadata
 		if err != nil {
 			return nil, err
 		}
-		return doCommitUpdates(tx, branchData, committer, message, []string{branchData.GetCommit()}, metadata, ts)
+		return doCommitUpdates(tx, branchData, committer, message, []string{branchData.GetCommit()}, metadata, ts, index.signer)
 	})
 	if err != nil {
 		return nil, err
@@ -792,7 +858,7 @@ func (index *KVIndex) GetCommit(repoId, commitId string) (*model.Commit, error)
 	if err != nil {
 		return nil, err
 	}
-	commit, err := index.kv.RepoReadTransact(repoId, func(tx store.RepoReadOnlyOperations) (i interface{}, err error) {
+	commit, err := index.kv.RepoReadTransactCtx(index.ctx, repoId, func(tx store.RepoReadOnlyOperations) (i interface{}, err error) {
 		return tx.ReadCommit(commitId)
 	})
 	if err != nil {
@@ -814,8 +880,8 @@ func (index *KVIndex) GetCommitLog(repoId, fromCommitId string, results int, aft
 	if err != nil {
 		return nil, false, err
 	}
-	res, err := index.kv.RepoReadTransact(repoId, func(tx store.RepoReadOnlyOperations) (i interface{}, err error) {
-		commits, hasMore, err := dag.BfsScan(tx, fromCommitId, results, after)
+	res, err := index.kv.RepoReadTransactCtx(index.ctx, repoId, func(tx store.RepoReadOnlyOperations) (i interface{}, err error) {
+		commits, hasMore, err := dag.BfsScan(index.ctx, tx, fromCommitId, results, after)
 		return &result{hasMore, commits}, err
 	})
 	if err != nil {
@@ -832,7 +898,7 @@ func (index *KVIndex) DeleteBranch(repoId, branch string) error {
 	if err != nil {
 		return err
 	}
-	_, err = index.kv.RepoTransact(repoId, func(tx store.RepoOperations) (interface{}, error) {
+	_, err = index.kv.RepoTransactCtx(index.ctx, repoId, func(tx store.RepoOperations) (interface{}, error) {
 		branchData, err := tx.ReadBranch(branch)
 		if err != nil {
 			return nil, err
@@ -859,8 +925,8 @@ func (index *KVIndex) DiffWorkspace(repoId, branch string) (merkle.Differences,
 	if err != nil {
 		return nil, err
 	}
-	res, err := index.kv.RepoTransact(repoId, func(tx store.RepoOperations) (i interface{}, err error) {
-		err = partialCommit(tx, branch) // ensure all changes are reflected in the tree
+	res, err := index.kv.RepoTransactCtx(index.ctx, repoId, func(tx store.RepoOperations) (i interface{}, err error) {
+		err = partialCommit(index.ctx, tx, branch, index.tsGenerator()) // ensure all changes are reflected in the tree
 		if err != nil {
 			return nil, err
 		}
@@ -932,7 +998,7 @@ func (index *KVIndex) Diff(repoId, leftRef, rightRef string) (merkle.Differences
 	if err != nil {
 		return nil, err
 	}
-	res, err := index.kv.RepoReadTransact(repoId, func(tx store.RepoReadOnlyOperations) (i interface{}, err error) {
+	res, err := index.kv.RepoReadTransactCtx(index.ctx, repoId, func(tx store.RepoReadOnlyOperations) (i interface{}, err error) {
 
 		return doDiff(tx, repoId, leftRef, rightRef, false, index)
 	})
@@ -954,7 +1020,7 @@ func (index *KVIndex) RevertCommit(repoId, branch, commit string) error {
 	if err != nil {
 		return err
 	}
-	_, err = index.kv.RepoTransact(repoId, func(tx store.RepoOperations) (interface{}, error) {
+	_, err = index.kv.RepoTransactCtx(index.ctx, repoId, func(tx store.RepoOperations) (interface{}, error) {
 		err := tx.ClearWorkspace(branch)
 		if err != nil {
 			log.WithError(err).Error("could not revert commit")
@@ -986,13 +1052,13 @@ func (index *KVIndex) revertPath(repoId, branch, path string, typ model.Entry_Ty
 		"branch": branch,
 		"path":   path,
 	})
-	_, err := index.kv.RepoTransact(repoId, func(tx store.RepoOperations) (interface{}, error) {
+	_, err := index.kv.RepoTransactCtx(index.ctx, repoId, func(tx store.RepoOperations) (interface{}, error) {
 		p := pth.New(path)
 		if p.IsRoot() {
 			return nil, index.ResetBranch(repoId, branch)
 		}
 
-		err := partialCommit(tx, branch)
+		err := partialCommit(index.ctx, tx, branch, index.tsGenerator())
 		if err != nil {
 			log.WithError(err).Error("could not partially commit")
 			return nil, err
@@ -1028,7 +1094,7 @@ func (index *KVIndex) revertPath(repoId, branch, path string, typ model.Entry_Ty
 			}
 		}
 		commitEntries := []*model.WorkspaceEntry{workspaceEntry}
-		workspaceMerkle, err = workspaceMerkle.Update(tx, commitEntries)
+		workspaceMerkle, err = workspaceMerkle.Update(index.ctx, tx, commitEntries)
 		if err != nil {
 			log.WithError(err).Error("could not update Merkle tree")
 			return nil, err
@@ -1072,56 +1138,192 @@ func (index *KVIndex) RevertObject(repoId, branch, path string) error {
 	return index.revertPath(repoId, branch, path, model.Entry_OBJECT)
 }
 
-func (index *KVIndex) Merge(repoId, source, destination, userId string) (merkle.Differences, error) {
+// MergeStrategy determines how KVIndex.Merge resolves paths that were modified
+// on both sides of a merge since their common base.
+type MergeStrategy int
+
+const (
+	// StrategyFailOnConflict aborts the merge and persists a resumable MergeState
+	// the first time a conflicting path is encountered. This is the default.
+	StrategyFailOnConflict MergeStrategy = iota
+	// StrategyOurs resolves conflicting paths by keeping the destination branch's entry.
+	StrategyOurs
+	// StrategyTheirs resolves conflicting paths by taking the source branch's entry.
+	StrategyTheirs
+	// StrategyRecursive auto-resolves a conflicting path when both sides
+	// independently converged on the same content (same object address),
+	// and otherwise falls back to StrategyFailOnConflict's behavior.
+	StrategyRecursive
+)
+
+// MergeOptions controls the behavior of KVIndex.Merge. A nil *MergeOptions is
+// equivalent to &MergeOptions{Strategy: StrategyFailOnConflict}.
+type MergeOptions struct {
+	Strategy MergeStrategy
+}
+
+func (o *MergeOptions) strategy() MergeStrategy {
+	if o == nil {
+		return StrategyFailOnConflict
+	}
+	return o.Strategy
+}
+
+// MergeState is the persisted, resumable state of a merge that stopped because
+// of conflicting paths. It is stored per-destination-branch so that AbortMerge
+// and ContinueMerge can find their way back to the in-progress merge. Resolved
+// tracks the paths ResolveConflict has already written into destination's
+// workspace, so ContinueMerge knows which of the remaining conflicting paths
+// it can trust and which still need the caller's attention.
+type MergeState struct {
+	Destination  string
+	Source       string
+	SourceCommit string
+	BaseCommit   string
+	Conflicts    merkle.Conflicts
+	Resolved     []string
+}
+
+// buildConflicts reads both sides of every conflicting path out of the source
+// and destination trees so callers can inspect what actually collided, rather
+// than just the path that did.
+func buildConflicts(tx store.RepoReadOnlyOperations, sourceRoot, destRoot string, diffs merkle.Differences) (merkle.Conflicts, error) {
+	var conflicts merkle.Conflicts
+	sourceTree := merkle.New(sourceRoot)
+	destTree := merkle.New(destRoot)
+	for _, dif := range diffs {
+		if dif.Direction != merkle.DifferenceDirectionConflict {
+			continue
+		}
+		sourceEntry, err := sourceTree.GetEntry(tx, dif.Path, dif.PathType)
+		if err != nil && !xerrors.Is(err, db.ErrNotFound) {
+			return nil, err
+		}
+		destEntry, err := destTree.GetEntry(tx, dif.Path, dif.PathType)
+		if err != nil && !xerrors.Is(err, db.ErrNotFound) {
+			return nil, err
+		}
+		conflicts = append(conflicts, merkle.Conflict{
+			Path:        dif.Path,
+			PathType:    dif.PathType,
+			Source:      sourceEntry,
+			Destination: destEntry,
+		})
+	}
+	return conflicts, nil
+}
+
+// findConflict returns the recorded conflict for path, or nil if there is none.
+func findConflict(conflicts merkle.Conflicts, path string) *merkle.Conflict {
+	for i := range conflicts {
+		if conflicts[i].Path == path {
+			return &conflicts[i]
+		}
+	}
+	return nil
+}
+
+// converged reports whether a conflict's two sides ended up with the same
+// object address - i.e. both branches made the same change independently,
+// so there's nothing left to actually reconcile.
+func converged(conflict *merkle.Conflict) bool {
+	if conflict == nil || conflict.Source == nil || conflict.Destination == nil {
+		return false
+	}
+	return conflict.Source.GetAddress() == conflict.Destination.GetAddress()
+}
+
+func (index *KVIndex) Merge(repoId, source, destination, userId string, opts *MergeOptions) (merkle.Differences, merkle.Conflicts, error) {
 	err := ValidateAll(
 		ValidateRepoId(repoId),
 		ValidateRef(source),
 		ValidateRef(destination))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	ts := index.tsGenerator()
+	strategy := opts.strategy()
 	var mergeOperations merkle.Differences
-	_, err = index.kv.RepoTransact(repoId, func(tx store.RepoOperations) (interface{}, error) {
+	var conflicts merkle.Conflicts
+	var pendingConflict *MergeState
+	_, err = index.kv.RepoTransactCtx(index.ctx, repoId, func(tx store.RepoOperations) (interface{}, error) {
+		if err := rebaseGuard(tx, destination); err != nil {
+			return nil, err
+		}
+		// a merge can't start while another one is already in progress on this branch
+		if _, err := tx.ReadMergeState(destination); err == nil {
+			return nil, errors.ErrMergeInProgress
+		} else if !xerrors.Is(err, db.ErrNotFound) {
+			return nil, err
+		}
 		// check that destination has no uncommitted changes
 		destinationBranch, err := tx.ReadBranch(destination)
 		if err != nil {
 			index.log().WithError(err).WithField("destination", destination).Warn(" branch " + destination + " not found")
 			return nil, errors.ErrBranchNotFound
 		}
-		l, err := tx.ListWorkspace(destination)
+		uncommitted, err := hasUncommittedChanges(tx, destination)
 		if err != nil {
 			index.log().WithError(err).WithField("destination", destination).Warn(" branch " + destination + " workspace not found")
 			return nil, err
 		}
-		if destinationBranch.GetCommitRoot() != destinationBranch.GetWorkspaceRoot() || len(l) > 0 {
+		if uncommitted {
 			return nil, errors.ErrDestinationNotCommitted
 		}
-		// compute difference
+		// compute difference against the merge base (the lowest common ancestor)
 		df, err := doDiff(tx, repoId, source, destination, true, index)
 		if err != nil {
 			return nil, err
 		}
-		var isConflict bool
+		sourceBranch, err := tx.ReadBranch(source)
+		if err != nil {
+			index.log().WithError(err).Fatal("failed reading source branch\n") // failure to read a branch that was read before fatal
+			return nil, err
+		}
+		conflicts, err = buildConflicts(tx, sourceBranch.GetWorkspaceRoot(), destinationBranch.GetCommitRoot(), df)
+		if err != nil {
+			return nil, err
+		}
 		for _, dif := range df {
 			if dif.Direction == merkle.DifferenceDirectionConflict {
-				isConflict = true
+				switch strategy {
+				case StrategyOurs:
+					continue // keep the destination's entry, nothing to apply
+				case StrategyTheirs:
+					// fall through, apply the source's entry below like any other change
+				case StrategyRecursive:
+					if converged(findConflict(conflicts, dif.Path)) {
+						continue // both sides made the same change, nothing to apply
+					}
+					fallthrough
+				default:
+					// Don't persist MergeState here: this closure still has to
+					// return a nil error so the transaction actually commits.
+					// Returning errors.ErrMergeConflict from inside the same
+					// transact call that wrote the state would risk the store
+					// rolling the write back along with everything else. Stash
+					// it and persist it in its own, separately-committing
+					// transaction once this one has returned cleanly below.
+					pendingConflict = &MergeState{
+						Destination:  destination,
+						Source:       source,
+						SourceCommit: sourceBranch.GetCommit(),
+						BaseCommit:   destinationBranch.GetCommit(),
+						Conflicts:    conflicts,
+					}
+					return nil, nil
+				}
 			}
 			if dif.Direction != merkle.DifferenceDirectionRight {
 				mergeOperations = append(mergeOperations, dif)
 			}
 		}
-		if isConflict {
-			return nil, errors.ErrMergeConflict
-		}
 		// update destination with source changes
 		var wsEntries []*model.WorkspaceEntry
-		sourceBranch, err := tx.ReadBranch(source)
-		if err != nil {
-			index.log().WithError(err).Fatal("failed reading source branch\n") // failure to read a branch that was read before fatal
-			return nil, err
-		}
 		for _, dif := range mergeOperations {
+			if err := index.ctx.Err(); err != nil {
+				return nil, err
+			}
 			var e *model.Entry
 			m := merkle.New(sourceBranch.GetWorkspaceRoot())
 			if dif.Type != merkle.DifferenceTypeRemoved {
@@ -1144,7 +1346,7 @@ func (index *KVIndex) Merge(repoId, source, destination, userId string) (merkle.
 		}
 
 		desinationRoot := merkle.New(destinationBranch.GetCommitRoot())
-		newRoot, err := desinationRoot.Update(tx, wsEntries)
+		newRoot, err := desinationRoot.Update(index.ctx, tx, wsEntries)
 		if err != nil {
 			index.log().WithError(err).Fatal("failed updating merge destination\n")
 			return nil, errors.ErrMergeUpdateFailed
@@ -1153,16 +1355,280 @@ func (index *KVIndex) Merge(repoId, source, destination, userId string) (merkle.
 		destinationBranch.WorkspaceRoot = newRoot.Root()
 		parents := []string{destinationBranch.GetCommit(), sourceBranch.GetCommit()}
 		commitMessage := "Merge branch " + source + " into " + destination
-		doCommitUpdates(tx, destinationBranch, userId, commitMessage, parents, make(map[string]string), ts)
+		doCommitUpdates(tx, destinationBranch, userId, commitMessage, parents, make(map[string]string), ts, index.signer)
 
 		return mergeOperations, nil
 
 	})
-	if err == nil || err == errors.ErrMergeConflict {
-		return mergeOperations, err
-	} else {
+	if err != nil {
+		return nil, nil, err
+	}
+	if pendingConflict != nil {
+		_, err = index.kv.RepoTransactCtx(index.ctx, repoId, func(tx store.RepoOperations) (interface{}, error) {
+			return nil, tx.WriteMergeState(destination, pendingConflict)
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, pendingConflict.Conflicts, errors.ErrMergeConflict
+	}
+	return mergeOperations, conflicts, nil
+}
+
+// AbortMerge discards an in-progress merge on destination, restoring it to the
+// state it was in before Merge was called. Merge itself never mutates the
+// destination branch until all conflicts are resolved, but ResolveConflict
+// writes resolved entries straight into destination's workspace while the
+// merge is still pending, so aborting must clear that workspace (the same
+// way ResetBranch does) in addition to dropping the persisted MergeState -
+// otherwise those entries linger as uncommitted changes and silently fold
+// into the next unrelated commit.
+func (index *KVIndex) AbortMerge(repoId, destination string) error {
+	err := ValidateAll(
+		ValidateRepoId(repoId),
+		ValidateRef(destination))
+	if err != nil {
+		return err
+	}
+	_, err = index.kv.RepoTransactCtx(index.ctx, repoId, func(tx store.RepoOperations) (interface{}, error) {
+		if _, err := tx.ReadMergeState(destination); err != nil {
+			return nil, err
+		}
+		if err := tx.ClearWorkspace(destination); err != nil {
+			return nil, err
+		}
+		branchData, err := tx.ReadBranch(destination)
+		if err != nil {
+			return nil, err
+		}
+		gc(tx, branchData.GetWorkspaceRoot())
+		branchData.WorkspaceRoot = branchData.GetCommitRoot()
+		if err := tx.WriteBranch(destination, branchData); err != nil {
+			return nil, err
+		}
+		return nil, tx.DeleteMergeState(destination)
+	})
+	return err
+}
+
+// ContinueMerge resumes an in-progress merge on destination once the caller
+// has resolved some or all of its conflicting paths via ResolveConflict. It
+// does not re-invoke Merge - by the time ResolveConflict has written a
+// resolution, destination's workspace already has uncommitted changes, which
+// would trip Merge's own ErrDestinationNotCommitted guard. Instead it
+// re-diffs source against destination the same way Merge did, applies every
+// non-conflicting change plus every conflicting path recorded in Resolved,
+// and commits the result. Any conflicting path that isn't in Resolved means
+// the merge is still blocked: the MergeState is refreshed and
+// ErrMergeConflict is returned again.
+func (index *KVIndex) ContinueMerge(repoId, destination, userId string) (merkle.Differences, error) {
+	err := ValidateAll(
+		ValidateRepoId(repoId),
+		ValidateRef(destination))
+	if err != nil {
 		return nil, err
 	}
+	ts := index.tsGenerator()
+	var mergeOperations merkle.Differences
+	var pendingConflict *MergeState
+	_, err = index.kv.RepoTransactCtx(index.ctx, repoId, func(tx store.RepoOperations) (interface{}, error) {
+		s, err := tx.ReadMergeState(destination)
+		if err != nil {
+			return nil, err
+		}
+		mergeState := s.(*MergeState)
+		resolved := make(map[string]bool, len(mergeState.Resolved))
+		for _, p := range mergeState.Resolved {
+			resolved[p] = true
+		}
+		repo, err := tx.ReadRepo()
+		if err != nil {
+			return nil, err
+		}
+		sourceBranch, err := tx.ReadBranch(mergeState.Source)
+		if err != nil {
+			return nil, err
+		}
+		destinationBranch, err := tx.ReadBranch(destination)
+		if err != nil {
+			return nil, err
+		}
+		df, err := doDiff(tx, repoId, mergeState.Source, destination, true, index)
+		if err != nil {
+			return nil, err
+		}
+		conflicts, err := buildConflicts(tx, sourceBranch.GetWorkspaceRoot(), destinationBranch.GetCommitRoot(), df)
+		if err != nil {
+			return nil, err
+		}
+		var stillConflicting merkle.Conflicts
+		for i := range conflicts {
+			if !resolved[conflicts[i].Path] {
+				stillConflicting = append(stillConflicting, conflicts[i])
+			}
+		}
+		if len(stillConflicting) > 0 {
+			// Stash rather than persist here: this closure must return a nil
+			// error for the transaction to actually commit. The refreshed
+			// MergeState is written in its own, separately-committing
+			// transaction below, once this one has returned cleanly.
+			mergeState.Conflicts = stillConflicting
+			pendingConflict = mergeState
+			return nil, nil
+		}
+		m := merkle.New(sourceBranch.GetWorkspaceRoot())
+		for _, dif := range df {
+			if dif.Direction == merkle.DifferenceDirectionConflict {
+				// already resolved directly on the workspace by ResolveConflict
+				continue
+			}
+			if dif.Direction == merkle.DifferenceDirectionRight {
+				continue
+			}
+			mergeOperations = append(mergeOperations, dif)
+			var e *model.Entry
+			if dif.Type != merkle.DifferenceTypeRemoved {
+				e, err = m.GetEntry(tx, dif.Path, dif.PathType)
+				if err != nil {
+					return nil, err
+				}
+			} else {
+				e = new(model.Entry)
+				e.Name = pth.New(dif.Path).Basename()
+				e.Type = dif.PathType
+			}
+			if err := writeEntryToWorkspace(index.ctx, tx, index.partialCommitPolicy, repo, destination, dif.Path, &model.WorkspaceEntry{
+				Path:      dif.Path,
+				Entry:     e,
+				Tombstone: dif.Type == merkle.DifferenceTypeRemoved,
+			}, ts); err != nil {
+				return nil, err
+			}
+		}
+		if err := partialCommit(index.ctx, tx, destination, ts); err != nil {
+			return nil, err
+		}
+		destinationBranch, err = tx.ReadBranch(destination)
+		if err != nil {
+			return nil, err
+		}
+		parents := []string{destinationBranch.GetCommit(), sourceBranch.GetCommit()}
+		commitMessage := "Merge branch " + mergeState.Source + " into " + destination
+		if _, err := doCommitUpdates(tx, destinationBranch, userId, commitMessage, parents, make(map[string]string), ts, index.signer); err != nil {
+			return nil, err
+		}
+		return nil, tx.DeleteMergeState(destination)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if pendingConflict != nil {
+		_, err = index.kv.RepoTransactCtx(index.ctx, repoId, func(tx store.RepoOperations) (interface{}, error) {
+			return nil, tx.WriteMergeState(destination, pendingConflict)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return nil, errors.ErrMergeConflict
+	}
+	return mergeOperations, nil
+}
+
+// GetMergeState returns the persisted MergeState for an in-progress merge on
+// destination, or an error if there is none.
+func (index *KVIndex) GetMergeState(repoId, destination string) (*MergeState, error) {
+	err := ValidateAll(
+		ValidateRepoId(repoId),
+		ValidateRef(destination))
+	if err != nil {
+		return nil, err
+	}
+	state, err := index.kv.RepoReadTransactCtx(index.ctx, repoId, func(tx store.RepoReadOnlyOperations) (interface{}, error) {
+		return tx.ReadMergeState(destination)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return state.(*MergeState), nil
+}
+
+// ConflictResolution picks which side of a conflicting path ResolveConflict
+// should write into the destination branch's workspace.
+type ConflictResolution int
+
+const (
+	// UseOurs keeps the destination branch's entry for the conflicting path.
+	UseOurs ConflictResolution = iota
+	// UseTheirs takes the source branch's entry for the conflicting path.
+	UseTheirs
+	// UseCustom writes a caller-supplied object in place of either side.
+	UseCustom
+)
+
+// ResolveConflict resolves a single conflicting path recorded in an
+// in-progress merge's MergeState, writing the chosen entry directly into
+// destination's workspace and dropping path from the pending conflict list.
+// Once every conflict has been resolved this way, call ContinueMerge to
+// commit the result. custom is only consulted when resolution is UseCustom.
+func (index *KVIndex) ResolveConflict(repoId, branch, path string, resolution ConflictResolution, custom *model.Object) error {
+	err := ValidateAll(
+		ValidateRepoId(repoId),
+		ValidateRef(branch),
+		ValidatePath(path))
+	if err != nil {
+		return err
+	}
+	ts := index.tsGenerator()
+	_, err = index.kv.RepoTransactCtx(index.ctx, repoId, func(tx store.RepoOperations) (interface{}, error) {
+		if err := rebaseGuard(tx, branch); err != nil {
+			return nil, err
+		}
+		s, err := tx.ReadMergeState(branch)
+		if err != nil {
+			return nil, err
+		}
+		mergeState := s.(*MergeState)
+		conflict := findConflict(mergeState.Conflicts, path)
+		if conflict == nil {
+			return nil, errors.ErrNoMergeBase
+		}
+		var e *model.Entry
+		switch resolution {
+		case UseOurs:
+			e = conflict.Destination
+		case UseTheirs:
+			e = conflict.Source
+		case UseCustom:
+			e = new(model.Entry)
+			e.Name = pth.New(path).Basename()
+			e.Type = conflict.PathType
+			e.Address = custom.GetAddress()
+			e.Size = custom.GetSize()
+			e.Checksum = custom.GetChecksum()
+			e.Timestamp = ts
+		}
+		repo, err := tx.ReadRepo()
+		if err != nil {
+			return nil, err
+		}
+		if err := writeEntryToWorkspace(index.ctx, tx, index.partialCommitPolicy, repo, branch, path, &model.WorkspaceEntry{
+			Path:      path,
+			Entry:     e,
+			Tombstone: e == nil,
+		}, ts); err != nil {
+			return nil, err
+		}
+		var remaining merkle.Conflicts
+		for _, c := range mergeState.Conflicts {
+			if c.Path != path {
+				remaining = append(remaining, c)
+			}
+		}
+		mergeState.Conflicts = remaining
+		mergeState.Resolved = append(mergeState.Resolved, path)
+		return nil, tx.WriteMergeState(branch, mergeState)
+	})
+	return err
 }
 
 func (index *KVIndex) CreateRepo(repoId, bucketName, defaultBranch string) error {
@@ -1183,7 +1649,7 @@ func (index *KVIndex) CreateRepo(repoId, bucketName, defaultBranch string) error
 	}
 
 	// create repository, an empty commit and tree, and the default branch
-	_, err = index.kv.RepoTransact(repoId, func(tx store.RepoOperations) (interface{}, error) {
+	_, err = index.kv.RepoTransactCtx(index.ctx, repoId, func(tx store.RepoOperations) (interface{}, error) {
 		// make sure this repo doesn't already exist
 		_, err := tx.ReadRepo()
 		if err == nil {
@@ -1230,7 +1696,7 @@ func (index *KVIndex) ListRepos(amount int, after string) ([]*model.Repo, bool,
 		repos   []*model.Repo
 		hasMore bool
 	}
-	res, err := index.kv.ReadTransact(func(tx store.ClientReadOnlyOperations) (interface{}, error) {
+	res, err := index.kv.ReadTransactCtx(index.ctx, func(tx store.ClientReadOnlyOperations) (interface{}, error) {
 		repos, hasMore, err := tx.ListRepos(amount, after)
 		return &result{
 			repos:   repos,
@@ -1250,7 +1716,7 @@ func (index *KVIndex) GetRepo(repoId string) (*model.Repo, error) {
 	if err != nil {
 		return nil, err
 	}
-	repo, err := index.kv.ReadTransact(func(tx store.ClientReadOnlyOperations) (interface{}, error) {
+	repo, err := index.kv.ReadTransactCtx(index.ctx, func(tx store.ClientReadOnlyOperations) (interface{}, error) {
 		return tx.ReadRepo(repoId)
 	})
 	if err != nil {
@@ -1259,13 +1725,17 @@ func (index *KVIndex) GetRepo(repoId string) (*model.Repo, error) {
 	return repo.(*model.Repo), nil
 }
 
+// DeleteRepo removes repoId and everything scoped to it - branches, commits
+// and tags alike, since tx.DeleteRepo drops the whole per-repo key
+// namespace tags are stored under rather than walking each collection
+// individually.
 func (index *KVIndex) DeleteRepo(repoId string) error {
 	err := ValidateAll(
 		ValidateRepoId(repoId))
 	if err != nil {
 		return err
 	}
-	_, err = index.kv.Transact(func(tx store.ClientOperations) (interface{}, error) {
+	_, err = index.kv.TransactCtx(index.ctx, func(tx store.ClientOperations) (interface{}, error) {
 		_, err := tx.ReadRepo(repoId)
 		if err != nil {
 			return nil, err
@@ -1287,8 +1757,8 @@ func (index *KVIndex) Tree(repoId, branch string) error {
 	if err != nil {
 		return err
 	}
-	_, err = index.kv.RepoTransact(repoId, func(tx store.RepoOperations) (interface{}, error) {
-		err := partialCommit(tx, branch)
+	_, err = index.kv.RepoTransactCtx(index.ctx, repoId, func(tx store.RepoOperations) (interface{}, error) {
+		err := partialCommit(index.ctx, tx, branch, index.tsGenerator())
 		if err != nil {
 			return nil, err
 		}
@@ -1301,7 +1771,7 @@ func (index *KVIndex) Tree(repoId, branch string) error {
 			return nil, err
 		}
 		m := merkle.New(r.GetWorkspaceRoot())
-		m.WalkAll(tx)
+		m.WalkAll(index.ctx, tx)
 		return nil, nil
 	})
 	return err
@@ -0,0 +1,133 @@
+package index
+
+import (
+	"strings"
+
+	"github.com/treeverse/lakefs/db"
+	"github.com/treeverse/lakefs/index/dag"
+	"github.com/treeverse/lakefs/index/errors"
+	"github.com/treeverse/lakefs/index/merkle"
+	"github.com/treeverse/lakefs/index/model"
+	"github.com/treeverse/lakefs/index/store"
+
+	"golang.org/x/xerrors"
+)
+
+// MergeMultiple performs an octopus merge: it folds the changes introduced by
+// every branch in sources into destination and records a single commit whose
+// Parents field lists the destination's previous tip followed by every source
+// tip, in order. Each source is merged in via its own three-way diff against
+// runningRoot, the destination's tree as built up by every source merged in so
+// far (not the destination's original, unmodified tree), so a conflict
+// introduced by the second source against the result of the first is reported
+// as ErrMergeConflict instead of being silently overwritten. Unlike Merge, no
+// MergeState is persisted and the conflicting paths are not returned.
+func (index *KVIndex) MergeMultiple(repoId string, sources []string, destination, userId, message string) (*model.Commit, error) {
+	err := ValidateAll(
+		ValidateRepoId(repoId),
+		ValidateRef(destination))
+	if err != nil {
+		return nil, err
+	}
+	for _, source := range sources {
+		if err := ValidateRef(source); err != nil {
+			return nil, err
+		}
+	}
+	if len(sources) == 0 {
+		return nil, errors.ErrNoMergeSource
+	}
+	ts := index.tsGenerator()
+	commit, err := index.kv.RepoTransactCtx(index.ctx, repoId, func(tx store.RepoOperations) (interface{}, error) {
+		if err := rebaseGuard(tx, destination); err != nil {
+			return nil, err
+		}
+		// an octopus merge can't start while a two-way merge is already in progress on this branch
+		if _, err := tx.ReadMergeState(destination); err == nil {
+			return nil, errors.ErrMergeInProgress
+		} else if !xerrors.Is(err, db.ErrNotFound) {
+			return nil, err
+		}
+		destinationBranch, err := tx.ReadBranch(destination)
+		if err != nil {
+			index.log().WithError(err).WithField("destination", destination).Warn(" branch " + destination + " not found")
+			return nil, errors.ErrBranchNotFound
+		}
+		uncommitted, err := hasUncommittedChanges(tx, destination)
+		if err != nil {
+			return nil, err
+		}
+		if uncommitted {
+			return nil, errors.ErrDestinationNotCommitted
+		}
+
+		parents := []string{destinationBranch.GetCommit()}
+		runningRoot := destinationBranch.GetCommitRoot()
+		for _, source := range sources {
+			sourceBranch, err := tx.ReadBranch(source)
+			if err != nil {
+				index.log().WithError(err).WithField("source", source).Warn(" branch " + source + " not found")
+				return nil, errors.ErrBranchNotFound
+			}
+			base, err := dag.FindLowestCommonAncestor(tx, sourceBranch.GetCommit(), destinationBranch.GetCommit())
+			if err != nil {
+				return nil, errors.ErrNoMergeBase
+			}
+			if base == nil {
+				return nil, errors.ErrNoMergeBase
+			}
+			df, err := merkle.Diff(tx,
+				merkle.New(sourceBranch.GetWorkspaceRoot()),
+				merkle.New(runningRoot),
+				merkle.New(base.GetTree()))
+			if err != nil {
+				return nil, err
+			}
+			conflicts, err := buildConflicts(tx, sourceBranch.GetWorkspaceRoot(), runningRoot, df)
+			if err != nil {
+				return nil, err
+			}
+			if len(conflicts) > 0 {
+				return nil, errors.ErrMergeConflict
+			}
+			var wsEntries []*model.WorkspaceEntry
+			for _, dif := range df {
+				if dif.Direction == merkle.DifferenceDirectionRight {
+					continue
+				}
+				var e *model.Entry
+				m := merkle.New(sourceBranch.GetWorkspaceRoot())
+				if dif.Type != merkle.DifferenceTypeRemoved {
+					e, err = m.GetEntry(tx, dif.Path, dif.PathType)
+					if err != nil {
+						return nil, err
+					}
+				} else {
+					e = new(model.Entry)
+					p := strings.Split(dif.Path, "/")
+					e.Name = p[len(p)-1]
+					e.Type = dif.PathType
+				}
+				wsEntries = append(wsEntries, &model.WorkspaceEntry{
+					Path:      dif.Path,
+					Entry:     e,
+					Tombstone: dif.Type == merkle.DifferenceTypeRemoved,
+				})
+			}
+			newRoot, err := merkle.New(runningRoot).Update(index.ctx, tx, wsEntries)
+			if err != nil {
+				return nil, errors.ErrMergeUpdateFailed
+			}
+			runningRoot = newRoot.Root()
+			parents = append(parents, sourceBranch.GetCommit())
+		}
+
+		destinationBranch.CommitRoot = runningRoot
+		destinationBranch.WorkspaceRoot = runningRoot
+		return doCommitUpdates(tx, destinationBranch, userId, message, parents, make(map[string]string), ts, index.signer)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return commit.(*model.Commit), nil
+}
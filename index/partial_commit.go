@@ -0,0 +1,86 @@
+package index
+
+import (
+	"math/rand"
+
+	"github.com/treeverse/lakefs/index/model"
+	"github.com/treeverse/lakefs/index/store"
+)
+
+// PartialCommitPolicy decides, after a write lands in a branch's workspace,
+// whether enough has accumulated that it should be eagerly folded into the
+// branch's immutable Merkle tree rather than waiting for an explicit Commit.
+// Implementations read their thresholds off the repo's own config
+// (model.Repo), so the write-amplification/read-latency trade-off can be
+// tuned per repo instead of process-wide. ts is the current logical time, as
+// produced by the index's TimeGenerator.
+type PartialCommitPolicy interface {
+	ShouldPartiallyCommit(tx store.RepoOperations, repo *model.Repo, branch string, ts int64) (bool, error)
+}
+
+// ProbabilisticPartialCommitPolicy is the original policy: every write has an
+// independent repo.GetPartialCommitRatio() chance of triggering a flush. It
+// bounds write amplification in expectation without tracking any state, which
+// makes it cheap but gives no hard guarantee on how stale the tree can get.
+type ProbabilisticPartialCommitPolicy struct{}
+
+func (ProbabilisticPartialCommitPolicy) ShouldPartiallyCommit(_ store.RepoOperations, repo *model.Repo, _ string, _ int64) (bool, error) {
+	return rand.Float32() < repo.GetPartialCommitRatio(), nil
+}
+
+// SizePartialCommitPolicy flushes once the branch's pending workspace holds
+// at least repo.GetPartialCommitMaxEntries() entries.
+type SizePartialCommitPolicy struct{}
+
+func (SizePartialCommitPolicy) ShouldPartiallyCommit(tx store.RepoOperations, repo *model.Repo, branch string, _ int64) (bool, error) {
+	max := repo.GetPartialCommitMaxEntries()
+	if max <= 0 {
+		return false, nil
+	}
+	entries, err := tx.ListWorkspace(branch)
+	if err != nil {
+		return false, err
+	}
+	return len(entries) >= int(max), nil
+}
+
+// ByteSizePartialCommitPolicy flushes once the pending workspace entries'
+// combined object size reaches repo.GetPartialCommitMaxBytes(). This suits
+// workloads where entry count is a poor proxy for how much work a flush
+// defers, e.g. a handful of very large objects rather than many small ones.
+type ByteSizePartialCommitPolicy struct{}
+
+func (ByteSizePartialCommitPolicy) ShouldPartiallyCommit(tx store.RepoOperations, repo *model.Repo, branch string, _ int64) (bool, error) {
+	max := repo.GetPartialCommitMaxBytes()
+	if max <= 0 {
+		return false, nil
+	}
+	entries, err := tx.ListWorkspace(branch)
+	if err != nil {
+		return false, err
+	}
+	var size int64
+	for _, entry := range entries {
+		size += entry.GetEntry().GetSize()
+	}
+	return size >= max, nil
+}
+
+// TimeBasedPartialCommitPolicy flushes once
+// repo.GetPartialCommitMaxAgeSeconds() has elapsed since the branch's last
+// partial commit, bounding how stale the committed tree can get regardless of
+// write volume. It relies on model.Branch.LastPartialCommitAt, which
+// partialCommit stamps on every flush.
+type TimeBasedPartialCommitPolicy struct{}
+
+func (TimeBasedPartialCommitPolicy) ShouldPartiallyCommit(tx store.RepoOperations, repo *model.Repo, branch string, ts int64) (bool, error) {
+	maxAge := repo.GetPartialCommitMaxAgeSeconds()
+	if maxAge <= 0 {
+		return false, nil
+	}
+	branchData, err := tx.ReadBranch(branch)
+	if err != nil {
+		return false, err
+	}
+	return ts-branchData.GetLastPartialCommitAt() >= maxAge, nil
+}
@@ -0,0 +1,430 @@
+package index
+
+import (
+	"context"
+
+	"github.com/treeverse/lakefs/db"
+	"github.com/treeverse/lakefs/ident"
+	"github.com/treeverse/lakefs/index/dag"
+	"github.com/treeverse/lakefs/index/errors"
+	"github.com/treeverse/lakefs/index/merkle"
+	"github.com/treeverse/lakefs/index/model"
+	pth "github.com/treeverse/lakefs/index/path"
+	"github.com/treeverse/lakefs/index/store"
+
+	"golang.org/x/xerrors"
+)
+
+// RebaseState is the persisted, resumable state of a rebase that is either
+// in progress or stopped on a conflicting commit. It is stored per-branch
+// (the rebase-in-progress marker) so that RebaseContinue, RebaseSkip and
+// RebaseAbort can find their way back to it, and so Commit and Merge can
+// refuse to race a branch that's mid-rebase.
+type RebaseState struct {
+	Branch      string
+	Onto        string
+	OriginalTip string   // branch's tip before Rebase started; restored by RebaseAbort
+	RebaseHead  string   // commit id the next replayed commit will be parented on
+	Remaining   []string // commit ids still to replay, oldest first; Remaining[0] is Current once set
+	Current     string   // commit id that produced the last conflict, if any
+	Conflicts   merkle.Conflicts
+}
+
+func commitIds(commits []*model.Commit) []string {
+	ids := make([]string, len(commits))
+	for i, c := range commits {
+		ids[i] = c.GetAddress()
+	}
+	return ids
+}
+
+// commitsSince walks branch's first-parent chain from tip back to (but not
+// including) base, and returns the commits in oldest-first order so they can
+// be replayed in the order they were originally made.
+func commitsSince(tx store.RepoReadOnlyOperations, tip, base string) ([]*model.Commit, error) {
+	var commits []*model.Commit
+	current := tip
+	for current != base {
+		commit, err := tx.ReadCommit(current)
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, commit)
+		parents := commit.GetParents()
+		if len(parents) == 0 {
+			break // walked past the root without finding base - replay everything we found
+		}
+		current = parents[0]
+	}
+	for i, j := 0, len(commits)-1; i < j; i, j = i+1, j-1 {
+		commits[i], commits[j] = commits[j], commits[i]
+	}
+	return commits, nil
+}
+
+// continueRebase replays rs.Remaining onto rs.RebaseHead one commit at a
+// time, using the same three-way merkle.Diff/Update primitives Merge uses:
+// for each commit, it diffs the commit's tree against its first parent's
+// tree (the patch it introduced) against the current rebase head (so
+// conflicts with commits already replayed, or with onto itself, are caught).
+//
+// If rs.Current (the commit that produced the last conflict) still conflicts,
+// continueRebase checks the conflicting paths against whatever the caller has
+// written directly into branch's live workspace since - the same way a
+// regular uncommitted change is made - and treats those paths as resolved
+// instead of reporting the same conflict again. This is what lets
+// RebaseContinue actually make progress; without it every call would just
+// recompute the identical diff from the stored commits and only RebaseSkip
+// could move the rebase forward.
+//
+// On conflict it sets rs.Current/rs.Conflicts and returns rs with a nil
+// error; it never persists rs itself, leaving that to the caller so the
+// write can happen in its own, separately-committing transaction (see
+// Rebase/RebaseContinue/RebaseSkip). Once Remaining is empty it fast-forwards
+// branch to the final rebase head and clears the persisted state.
+func continueRebase(ctx context.Context, tx store.RepoOperations, index *KVIndex, rs *RebaseState, userId string, ts int64) (*RebaseState, error) {
+	for len(rs.Remaining) > 0 {
+		commitId := rs.Remaining[0]
+		commitData, err := tx.ReadCommit(commitId)
+		if err != nil {
+			return nil, err
+		}
+		parentTree, err := firstParentTree(tx, commitData)
+		if err != nil {
+			return nil, err
+		}
+		headCommit, err := tx.ReadCommit(rs.RebaseHead)
+		if err != nil {
+			return nil, err
+		}
+
+		diffs, err := merkle.Diff(tx,
+			merkle.New(commitData.GetTree()),
+			merkle.New(headCommit.GetTree()),
+			merkle.New(parentTree))
+		if err != nil {
+			return nil, err
+		}
+		conflicts, err := buildConflicts(tx, commitData.GetTree(), headCommit.GetTree(), diffs)
+		if err != nil {
+			return nil, err
+		}
+
+		// Only the paths this commit actually conflicted on count as resolved,
+		// and only if the caller wrote a resolution for that exact path -
+		// never the whole workspace, which may also hold unrelated pending
+		// writes that have nothing to do with this conflict.
+		resolved := make(map[string]*model.WorkspaceEntry)
+		if len(conflicts) > 0 && commitId == rs.Current {
+			for i := range conflicts {
+				we, err := tx.ReadFromWorkspace(rs.Branch, conflicts[i].Path)
+				if err != nil {
+					if xerrors.Is(err, db.ErrNotFound) {
+						continue
+					}
+					return nil, err
+				}
+				resolved[conflicts[i].Path] = we
+			}
+		}
+		var unresolved merkle.Conflicts
+		for i := range conflicts {
+			if _, ok := resolved[conflicts[i].Path]; !ok {
+				unresolved = append(unresolved, conflicts[i])
+			}
+		}
+		if len(unresolved) > 0 {
+			rs.Current = commitId
+			rs.Conflicts = unresolved
+			return rs, nil
+		}
+
+		m := merkle.New(commitData.GetTree())
+		var wsEntries []*model.WorkspaceEntry
+		for _, dif := range diffs {
+			if dif.Direction == merkle.DifferenceDirectionRight {
+				continue // already part of the rebase head, nothing to replay
+			}
+			if re, ok := resolved[dif.Path]; ok {
+				wsEntries = append(wsEntries, re)
+				continue
+			}
+			var e *model.Entry
+			if dif.Type != merkle.DifferenceTypeRemoved {
+				e, err = m.GetEntry(tx, dif.Path, dif.PathType)
+				if err != nil {
+					return nil, err
+				}
+			} else {
+				e = new(model.Entry)
+				e.Name = pth.New(dif.Path).Basename()
+				e.Type = dif.PathType
+			}
+			wsEntries = append(wsEntries, &model.WorkspaceEntry{
+				Path:      dif.Path,
+				Entry:     e,
+				Tombstone: dif.Type == merkle.DifferenceTypeRemoved,
+			})
+		}
+		for p := range resolved {
+			// this resolution has now been folded into the replayed tree;
+			// drop just this path, not the whole workspace, so unrelated
+			// pending writes on branch survive untouched.
+			if err := tx.DeleteWorkspacePath(rs.Branch, p); err != nil {
+				return nil, err
+			}
+		}
+		newTree, err := merkle.New(headCommit.GetTree()).Update(ctx, tx, wsEntries)
+		if err != nil {
+			return nil, err
+		}
+
+		newCommit := &model.Commit{
+			Tree:      newTree.Root(),
+			Parents:   []string{rs.RebaseHead},
+			Committer: commitData.GetCommitter(),
+			Message:   commitData.GetMessage(),
+			Timestamp: ts,
+			Metadata:  commitData.GetMetadata(),
+		}
+		if index.signer != nil {
+			sig, err := index.signer.Sign(ident.Payload(newCommit))
+			if err != nil {
+				return nil, xerrors.Errorf("could not sign commit: %w", err)
+			}
+			newCommit.Signature = sig
+			newCommit.SignerKeyId = index.signer.KeyId()
+		}
+		newCommit.Address = ident.Hash(newCommit)
+		if err := tx.WriteCommit(newCommit.Address, newCommit); err != nil {
+			return nil, err
+		}
+		rs.RebaseHead = newCommit.Address
+		rs.Remaining = rs.Remaining[1:]
+		rs.Current = ""
+		rs.Conflicts = nil
+	}
+
+	branchData, err := tx.ReadBranch(rs.Branch)
+	if err != nil {
+		return nil, err
+	}
+	headCommit, err := tx.ReadCommit(rs.RebaseHead)
+	if err != nil {
+		return nil, err
+	}
+	branchData.Commit = rs.RebaseHead
+	branchData.CommitRoot = headCommit.GetTree()
+	branchData.WorkspaceRoot = headCommit.GetTree()
+	if err := tx.WriteBranch(rs.Branch, branchData); err != nil {
+		return nil, err
+	}
+	if err := tx.DeleteRebaseState(rs.Branch); err != nil && !xerrors.Is(err, db.ErrNotFound) {
+		return nil, err
+	}
+	rs.Current = ""
+	rs.Conflicts = nil
+	return rs, nil
+}
+
+// rebaseGuard refuses to proceed if branch has a rebase in progress, so
+// Commit and Merge can't race a rebase into an inconsistent branch pointer.
+func rebaseGuard(tx store.RepoOperations, branch string) error {
+	if _, err := tx.ReadRebaseState(branch); err == nil {
+		return errors.ErrRebaseInProgress
+	} else if !xerrors.Is(err, db.ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+// persistRebaseConflict writes rs's conflict state in its own, separately-
+// committing transaction. continueRebase never writes it itself: doing so
+// from inside the same transaction that then reports ErrRebaseConflict would
+// risk the store rolling the write back along with everything else.
+func persistRebaseConflict(index *KVIndex, repoId string, rs *RebaseState) error {
+	_, err := index.kv.RepoTransactCtx(index.ctx, repoId, func(tx store.RepoOperations) (interface{}, error) {
+		return nil, tx.WriteRebaseState(rs.Branch, rs)
+	})
+	return err
+}
+
+// Rebase replays the commits unique to branch (since its merge base with
+// onto) on top of onto's current tip, one at a time, producing a linear
+// history instead of the two-parent merge commit Merge would create. It
+// mirrors libgit2's interactive rebase model: on conflict it stops and
+// persists a resumable RebaseState for RebaseContinue/RebaseSkip/RebaseAbort
+// to act on, and while in progress it blocks Commit and Merge on branch.
+func (index *KVIndex) Rebase(repoId, branch, onto, userId string) (*RebaseState, error) {
+	err := ValidateAll(
+		ValidateRepoId(repoId),
+		ValidateRef(branch),
+		ValidateRef(onto))
+	if err != nil {
+		return nil, err
+	}
+	ts := index.tsGenerator()
+	var rs *RebaseState
+	_, err = index.kv.RepoTransactCtx(index.ctx, repoId, func(tx store.RepoOperations) (interface{}, error) {
+		if err := rebaseGuard(tx, branch); err != nil {
+			return nil, err
+		}
+		branchData, err := tx.ReadBranch(branch)
+		if err != nil {
+			return nil, err
+		}
+		uncommitted, err := hasUncommittedChanges(tx, branch)
+		if err != nil {
+			return nil, err
+		}
+		if uncommitted {
+			return nil, errors.ErrDestinationNotCommitted
+		}
+		ontoRef, err := resolveRef(tx, onto)
+		if err != nil {
+			return nil, err
+		}
+		base, err := dag.FindLowestCommonAncestor(tx, branchData.GetCommit(), ontoRef.commit.GetAddress())
+		if err != nil {
+			return nil, errors.ErrNoMergeBase
+		}
+		if base == nil {
+			return nil, errors.ErrNoMergeBase
+		}
+		commits, err := commitsSince(tx, branchData.GetCommit(), base.GetAddress())
+		if err != nil {
+			return nil, err
+		}
+		initial := &RebaseState{
+			Branch:      branch,
+			Onto:        onto,
+			OriginalTip: branchData.GetCommit(),
+			RebaseHead:  ontoRef.commit.GetAddress(),
+			Remaining:   commitIds(commits),
+		}
+		rs, err = continueRebase(index.ctx, tx, index, initial, userId, ts)
+		return nil, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if rs.Current != "" {
+		if err := persistRebaseConflict(index, repoId, rs); err != nil {
+			return nil, err
+		}
+		return rs, errors.ErrRebaseConflict
+	}
+	return rs, nil
+}
+
+// RebaseContinue resumes an in-progress rebase on branch after the caller has
+// resolved the conflict recorded in its RebaseState by writing the resolved
+// entries directly into branch's workspace, retrying the commit that stopped
+// the rebase before proceeding to the rest of Remaining.
+func (index *KVIndex) RebaseContinue(repoId, branch, userId string) (*RebaseState, error) {
+	err := ValidateAll(
+		ValidateRepoId(repoId),
+		ValidateRef(branch))
+	if err != nil {
+		return nil, err
+	}
+	ts := index.tsGenerator()
+	var rs *RebaseState
+	_, err = index.kv.RepoTransactCtx(index.ctx, repoId, func(tx store.RepoOperations) (interface{}, error) {
+		s, err := tx.ReadRebaseState(branch)
+		if err != nil {
+			return nil, err
+		}
+		rs, err = continueRebase(index.ctx, tx, index, s.(*RebaseState), userId, ts)
+		return nil, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if rs.Current != "" {
+		if err := persistRebaseConflict(index, repoId, rs); err != nil {
+			return nil, err
+		}
+		return rs, errors.ErrRebaseConflict
+	}
+	return rs, nil
+}
+
+// RebaseSkip drops the commit that's currently conflicting from an
+// in-progress rebase on branch and continues with the rest of Remaining.
+func (index *KVIndex) RebaseSkip(repoId, branch, userId string) (*RebaseState, error) {
+	err := ValidateAll(
+		ValidateRepoId(repoId),
+		ValidateRef(branch))
+	if err != nil {
+		return nil, err
+	}
+	ts := index.tsGenerator()
+	var rs *RebaseState
+	_, err = index.kv.RepoTransactCtx(index.ctx, repoId, func(tx store.RepoOperations) (interface{}, error) {
+		s, err := tx.ReadRebaseState(branch)
+		if err != nil {
+			return nil, err
+		}
+		skipped := s.(*RebaseState)
+		if len(skipped.Remaining) > 0 {
+			skipped.Remaining = skipped.Remaining[1:]
+		}
+		skipped.Current = ""
+		skipped.Conflicts = nil
+		rs, err = continueRebase(index.ctx, tx, index, skipped, userId, ts)
+		return nil, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if rs.Current != "" {
+		if err := persistRebaseConflict(index, repoId, rs); err != nil {
+			return nil, err
+		}
+		return rs, errors.ErrRebaseConflict
+	}
+	return rs, nil
+}
+
+// RebaseAbort discards an in-progress rebase on branch, restoring its commit
+// pointer to the tip it had before Rebase was called. The documented recovery
+// flow for a conflicting commit has the caller write resolved entries
+// directly into branch's workspace, so aborting must also clear that
+// workspace - otherwise those entries survive the abort and either get
+// silently folded into the next unrelated commit or leave branch looking
+// uncommitted.
+func (index *KVIndex) RebaseAbort(repoId, branch string) error {
+	err := ValidateAll(
+		ValidateRepoId(repoId),
+		ValidateRef(branch))
+	if err != nil {
+		return err
+	}
+	_, err = index.kv.RepoTransactCtx(index.ctx, repoId, func(tx store.RepoOperations) (interface{}, error) {
+		s, err := tx.ReadRebaseState(branch)
+		if err != nil {
+			return nil, err
+		}
+		rs := s.(*RebaseState)
+		if err := tx.ClearWorkspace(branch); err != nil {
+			return nil, err
+		}
+		branchData, err := tx.ReadBranch(branch)
+		if err != nil {
+			return nil, err
+		}
+		originalCommit, err := tx.ReadCommit(rs.OriginalTip)
+		if err != nil {
+			return nil, err
+		}
+		branchData.Commit = rs.OriginalTip
+		branchData.CommitRoot = originalCommit.GetTree()
+		branchData.WorkspaceRoot = originalCommit.GetTree()
+		if err := tx.WriteBranch(branch, branchData); err != nil {
+			return nil, err
+		}
+		return nil, tx.DeleteRebaseState(branch)
+	})
+	return err
+}
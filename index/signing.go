@@ -0,0 +1,99 @@
+package index
+
+import (
+	"github.com/treeverse/lakefs/db"
+	"github.com/treeverse/lakefs/ident"
+	"github.com/treeverse/lakefs/index/store"
+
+	"golang.org/x/xerrors"
+)
+
+// Signer produces a detached signature over a commit's canonical payload (the
+// same bytes ident.Hash uses to address the commit), and identifies itself by
+// a KeyId that VerifyCommit later resolves against a repo's keyring.
+type Signer interface {
+	Sign(payload []byte) ([]byte, error)
+	KeyId() string
+}
+
+// WithCommitSigner causes every commit written through this KVIndex to carry
+// a detached signature, similar to `git commit -S`. The initial, empty commit
+// CreateRepo writes is the exception: it is never signed.
+func WithCommitSigner(signer Signer) Option {
+	return func(kvi *KVIndex) {
+		kvi.signer = signer
+	}
+}
+
+// SignatureState describes the outcome of verifying a commit's signature.
+type SignatureState int
+
+const (
+	SignatureStateUnsigned SignatureState = iota
+	SignatureStateValid
+	SignatureStateInvalid
+	SignatureStateUnknownSigner
+)
+
+// SignatureStatus is the result of VerifyCommit: whether the commit's
+// signature checks out, and against which signer identity it was verified.
+type SignatureStatus struct {
+	State  SignatureState
+	Signer string
+}
+
+// RegisterSignerKey adds a public key to repoId's keyring under keyId, so that
+// commits signed by the matching Signer can later be verified with VerifyCommit.
+func (index *KVIndex) RegisterSignerKey(repoId, keyId string, publicKey []byte) error {
+	err := ValidateAll(ValidateRepoId(repoId))
+	if err != nil {
+		return err
+	}
+	_, err = index.kv.RepoTransactCtx(index.ctx, repoId, func(tx store.RepoOperations) (interface{}, error) {
+		return nil, tx.WriteSignerKey(keyId, publicKey)
+	})
+	return err
+}
+
+// VerifyCommit checks commitId's signature, if any, against repoId's keyring.
+func (index *KVIndex) VerifyCommit(repoId, commitId string) (*SignatureStatus, error) {
+	err := ValidateAll(
+		ValidateRepoId(repoId),
+		ValidateCommitID(commitId))
+	if err != nil {
+		return nil, err
+	}
+	status, err := index.kv.RepoReadTransactCtx(index.ctx, repoId, func(tx store.RepoReadOnlyOperations) (interface{}, error) {
+		commit, err := tx.ReadCommit(commitId)
+		if err != nil {
+			return nil, err
+		}
+		if len(commit.GetSignature()) == 0 {
+			return &SignatureStatus{State: SignatureStateUnsigned}, nil
+		}
+		keyId := commit.GetSignerKeyId()
+		publicKey, err := tx.ReadSignerKey(keyId)
+		if err != nil {
+			if xerrors.Is(err, db.ErrNotFound) {
+				return &SignatureStatus{State: SignatureStateUnknownSigner, Signer: keyId}, nil
+			}
+			return nil, err
+		}
+		// the payload signed is the commit's canonical payload as it stood
+		// before Signature, SignerKeyId and Address were set, so all three are
+		// cleared here to reproduce exactly those bytes.
+		unsigned := *commit
+		unsigned.Signature = nil
+		unsigned.SignerKeyId = ""
+		unsigned.Address = ""
+		if err := ident.VerifySignature(publicKey, ident.Payload(&unsigned), commit.GetSignature()); err != nil {
+			return &SignatureStatus{State: SignatureStateInvalid, Signer: keyId}, nil
+		}
+		return &SignatureStatus{State: SignatureStateValid, Signer: keyId}, nil
+	})
+	if err != nil {
+		index.log().WithError(err).WithField("commit", commitId).Error("could not verify commit signature")
+		return nil, err
+	}
+	return status.(*SignatureStatus), nil
+}
@@ -0,0 +1,139 @@
+package index
+
+import (
+	"github.com/treeverse/lakefs/index/merkle"
+	"github.com/treeverse/lakefs/index/model"
+	"github.com/treeverse/lakefs/index/store"
+)
+
+// StatusEntry describes a single uncommitted change to a path, as reported by
+// Status. OldAddress/NewAddress are left empty when there is no old or new
+// side respectively (a pure add has no OldAddress, a pure remove has no
+// NewAddress).
+type StatusEntry struct {
+	Path       string
+	PathType   model.Entry_Type
+	OldAddress string
+	NewAddress string
+	Size       int64
+}
+
+// WorkspaceStatus is the equivalent of `git status`: every uncommitted change
+// on a branch, grouped by the kind of change it represents.
+type WorkspaceStatus struct {
+	Added    []StatusEntry
+	Modified []StatusEntry
+	Removed  []StatusEntry
+}
+
+// hasUncommittedChanges is the predicate Merge, CherryPick/RevertAsCommit and
+// Rebase all use to refuse to start while branch has pending, uncommitted
+// writes: its workspace root must match its commit root, and its workspace
+// listing must be empty. It's factored out here so Status's
+// HasUncommittedChanges can expose the same check as a public capability
+// instead of each caller re-deriving it inline.
+func hasUncommittedChanges(tx store.RepoReadOnlyOperations, branch string) (bool, error) {
+	branchData, err := tx.ReadBranch(branch)
+	if err != nil {
+		return false, err
+	}
+	l, err := tx.ListWorkspace(branch)
+	if err != nil {
+		return false, err
+	}
+	return branchData.GetCommitRoot() != branchData.GetWorkspaceRoot() || len(l) > 0, nil
+}
+
+// HasUncommittedChanges reports whether branch has any pending, uncommitted
+// writes - the same check Merge uses to guard ErrDestinationNotCommitted,
+// exposed as a standalone, cheaper alternative to Status for callers that
+// only need a yes/no answer.
+func (index *KVIndex) HasUncommittedChanges(repoId, branch string) (bool, error) {
+	err := ValidateAll(
+		ValidateRepoId(repoId),
+		ValidateRef(branch))
+	if err != nil {
+		return false, err
+	}
+	has, err := index.kv.RepoReadTransactCtx(index.ctx, repoId, func(tx store.RepoReadOnlyOperations) (interface{}, error) {
+		return hasUncommittedChanges(tx, branch)
+	})
+	if err != nil {
+		return false, err
+	}
+	return has.(bool), nil
+}
+
+// Status reports every uncommitted change on branch - added, modified and
+// removed entries - by diffing its WorkspaceRoot against its CommitRoot,
+// using the commit root as the base on both sides of the three-way diff
+// (there is no real merge base here, only "what changed since the last
+// commit"). It calls partialCommit first, the same way DiffWorkspace does,
+// so raw workspace entries the partial-commit policy hasn't flushed into the
+// tree yet are still reflected in the report.
+func (index *KVIndex) Status(repoId, branch string) (*WorkspaceStatus, error) {
+	err := ValidateAll(
+		ValidateRepoId(repoId),
+		ValidateRef(branch))
+	if err != nil {
+		return nil, err
+	}
+	status, err := index.kv.RepoTransactCtx(index.ctx, repoId, func(tx store.RepoOperations) (interface{}, error) {
+		err := partialCommit(index.ctx, tx, branch, index.tsGenerator())
+		if err != nil {
+			return nil, err
+		}
+		branchData, err := tx.ReadBranch(branch)
+		if err != nil {
+			return nil, err
+		}
+		diffs, err := merkle.Diff(tx,
+			merkle.New(branchData.GetWorkspaceRoot()),
+			merkle.New(branchData.GetCommitRoot()),
+			merkle.New(branchData.GetCommitRoot()))
+		if err != nil {
+			return nil, err
+		}
+		workspaceTree := merkle.New(branchData.GetWorkspaceRoot())
+		commitTree := merkle.New(branchData.GetCommitRoot())
+		status := &WorkspaceStatus{}
+		for _, dif := range diffs {
+			entry := StatusEntry{Path: dif.Path, PathType: dif.PathType}
+			switch dif.Type {
+			case merkle.DifferenceTypeAdded:
+				e, err := workspaceTree.GetEntry(tx, dif.Path, dif.PathType)
+				if err != nil {
+					return nil, err
+				}
+				entry.NewAddress = e.GetAddress()
+				entry.Size = e.GetSize()
+				status.Added = append(status.Added, entry)
+			case merkle.DifferenceTypeChanged:
+				newEntry, err := workspaceTree.GetEntry(tx, dif.Path, dif.PathType)
+				if err != nil {
+					return nil, err
+				}
+				oldEntry, err := commitTree.GetEntry(tx, dif.Path, dif.PathType)
+				if err != nil {
+					return nil, err
+				}
+				entry.OldAddress = oldEntry.GetAddress()
+				entry.NewAddress = newEntry.GetAddress()
+				entry.Size = newEntry.GetSize()
+				status.Modified = append(status.Modified, entry)
+			case merkle.DifferenceTypeRemoved:
+				oldEntry, err := commitTree.GetEntry(tx, dif.Path, dif.PathType)
+				if err != nil {
+					return nil, err
+				}
+				entry.OldAddress = oldEntry.GetAddress()
+				status.Removed = append(status.Removed, entry)
+			}
+		}
+		return status, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return status.(*WorkspaceStatus), nil
+}
@@ -0,0 +1,122 @@
+package index
+
+import (
+	"github.com/treeverse/lakefs/db"
+	"github.com/treeverse/lakefs/index/errors"
+	"github.com/treeverse/lakefs/index/model"
+	"github.com/treeverse/lakefs/index/store"
+
+	"golang.org/x/xerrors"
+)
+
+// CreateTag creates an immutable, named reference to ref's current commit.
+// Unlike branches, tags never move: re-creating an existing tag name returns
+// ErrTagAlreadyExists, and since resolveRef accepts both branch and tag names
+// wherever a ref is expected, a tag may also not shadow an existing branch
+// name. A tag is marked Annotated when message is non-empty, and lightweight
+// otherwise - mirroring git's own distinction. Committer and CreationDate are
+// recorded on both kinds.
+func (index *KVIndex) CreateTag(repoId, tag, ref, committer, message string) (*model.Tag, error) {
+	err := ValidateAll(
+		ValidateRepoId(repoId),
+		ValidateRef(tag),
+		ValidateRef(ref))
+	if err != nil {
+		return nil, err
+	}
+	ts := index.tsGenerator()
+	tagData, err := index.kv.RepoTransactCtx(index.ctx, repoId, func(tx store.RepoOperations) (interface{}, error) {
+		_, err := tx.ReadTag(tag)
+		if err != nil && !xerrors.Is(err, db.ErrNotFound) {
+			return nil, err
+		} else if err == nil {
+			return nil, errors.ErrTagAlreadyExists
+		}
+		if _, err := tx.ReadBranch(tag); err == nil {
+			return nil, errors.ErrBranchAlreadyExists
+		} else if !xerrors.Is(err, db.ErrNotFound) {
+			return nil, err
+		}
+		reference, err := resolveRef(tx, ref)
+		if err != nil {
+			return nil, xerrors.Errorf("could not read ref: %w", err)
+		}
+		tagData := &model.Tag{
+			Name:         tag,
+			CommitId:     reference.commit.GetAddress(),
+			Committer:    committer,
+			Message:      message,
+			CreationDate: ts,
+			Annotated:    message != "",
+		}
+		return tagData, tx.WriteTag(tag, tagData)
+	})
+	if err != nil {
+		index.log().WithError(err).WithField("ref", ref).Error("could not create tag")
+		return nil, err
+	}
+	return tagData.(*model.Tag), nil
+}
+
+// GetTag returns the named tag. If it doesn't exist, the store's not-found
+// error (db.ErrNotFound) is returned as is.
+func (index *KVIndex) GetTag(repoId, tag string) (*model.Tag, error) {
+	err := ValidateAll(
+		ValidateRepoId(repoId),
+		ValidateRef(tag))
+	if err != nil {
+		return nil, err
+	}
+	tagData, err := index.kv.RepoReadTransactCtx(index.ctx, repoId, func(tx store.RepoReadOnlyOperations) (interface{}, error) {
+		return tx.ReadTag(tag)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tagData.(*model.Tag), nil
+}
+
+// DeleteTag removes a tag. It does not affect the commit it pointed to.
+func (index *KVIndex) DeleteTag(repoId, tag string) error {
+	err := ValidateAll(
+		ValidateRepoId(repoId),
+		ValidateRef(tag))
+	if err != nil {
+		return err
+	}
+	_, err = index.kv.RepoTransactCtx(index.ctx, repoId, func(tx store.RepoOperations) (interface{}, error) {
+		_, err := tx.ReadTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		return nil, tx.DeleteTag(tag)
+	})
+	return err
+}
+
+// ListTags lists all tags on the repo, paginated the same way ListRepos is.
+func (index *KVIndex) ListTags(repoId string, amount int, after string) ([]*model.Tag, bool, error) {
+	return index.ListTagsByPrefix(repoId, "", amount, after)
+}
+
+// ListTagsByPrefix lists tags whose name starts with prefix, paginated the
+// same way ListBranchesByPrefix is.
+func (index *KVIndex) ListTagsByPrefix(repoId, prefix string, amount int, after string) ([]*model.Tag, bool, error) {
+	err := ValidateAll(ValidateRepoId(repoId))
+	if err != nil {
+		return nil, false, err
+	}
+	type result struct {
+		tags    []*model.Tag
+		hasMore bool
+	}
+	res, err := index.kv.RepoReadTransactCtx(index.ctx, repoId, func(tx store.RepoReadOnlyOperations) (interface{}, error) {
+		tags, hasMore, err := tx.ListTags(prefix, amount, after)
+		return &result{tags, hasMore}, err
+	})
+	if err != nil {
+		index.log().WithError(err).Error("could not list tags")
+		return nil, false, err
+	}
+	return res.(*result).tags, res.(*result).hasMore, nil
+}